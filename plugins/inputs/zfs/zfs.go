@@ -0,0 +1,562 @@
+package zfs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+type Zfs struct {
+	KstatPath      string
+	KstatMetrics   []string
+	PoolMetrics    bool
+	DatasetMetrics bool
+	VdevMetrics    bool
+	PoolProperties bool
+	zdataset       func([]string) ([]string, error)
+
+	Log telegraf.Logger `toml:"-"`
+}
+
+func (z *Zfs) Description() string {
+	return "Read metrics of ZFS from arcstats, zpools and datasets"
+}
+
+var zfsSampleConfig = `
+  ## ZFS kstat path. Ignored on FreeBSD and Illumos/Solaris
+  ## If not specified, then default is:
+  # kstatPath = "/proc/spl/kstat/zfs"
+
+  ## By default, telegraf gather all zfs stats
+  ## If not specified, then default is:
+  # kstatMetrics = ["abdstats", "arcstats", "dnodestats", "dbufcachestats",
+  #   "dmu_tx", "fm", "vdev_mirror_stats", "zfetchstats", "zil"]
+
+  ## By default, don't gather zpool stats
+  # poolMetrics = false
+
+  ## By default, don't gather dataset stats
+  # datasetMetrics = false
+
+  ## By default, don't gather per-vdev stats
+  # vdevMetrics = false
+
+  ## By default, don't gather zpool capacity, fragmentation and dedup stats
+  # poolProperties = false
+`
+
+func (z *Zfs) SampleConfig() string {
+	return zfsSampleConfig
+}
+
+// poolInfo is the set of pools found on the host, as reported by `zpool
+// list`, which (unlike the kstat layout) is identical across every
+// platform ZFS runs on.
+type poolInfo struct {
+	name string
+}
+
+// kstatReader abstracts reading raw ZFS kstat counters across the
+// platforms ZFS runs on: Linux exposes them as procfs text files, FreeBSD
+// as a sysctl(8) tree, and Illumos via the kstat(1) facility/library. Each
+// platform file implements kstatReader and provides newKstatReader, so
+// Gather and the measurement/tag/field schema it produces stay the same
+// everywhere.
+type kstatReader interface {
+	// poolIO returns the raw io kstat counters for the named pool, keyed
+	// by counter name (e.g. "nread", "nwritten").
+	poolIO(pool string) (map[string]interface{}, error)
+	// subsystem returns the named fields of one kstat subsystem (e.g.
+	// "arcstats", "abdstats"), keyed by counter name and typed as int64
+	// or uint64 according to their declared kstat type where the platform
+	// exposes it (Linux procfs); readers that can't see the declared type
+	// report every counter as uint64. Rows that fail to parse are returned
+	// as errs rather than failing the whole read.
+	subsystem(metric string) (fields map[string]interface{}, errs []error)
+}
+
+func getPools() ([]poolInfo, error) {
+	lines, err := run("zpool", "list", "-Ho", "name")
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make([]poolInfo, 0, len(lines))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		pools = append(pools, poolInfo{name: line})
+	}
+
+	return pools, nil
+}
+
+func getTags(pools []poolInfo) map[string]string {
+	var poolNames string
+
+	for _, pool := range pools {
+		if len(poolNames) != 0 {
+			poolNames += "::"
+		}
+		poolNames += pool.name
+	}
+
+	return map[string]string{"pools": poolNames}
+}
+
+func (z *Zfs) gatherDatasetStats(acc telegraf.Accumulator) (string, error) {
+	properties := []string{"name", "avail", "used", "usedsnap", "usedds"}
+
+	lines, err := z.zdataset(properties)
+	if err != nil {
+		return "", err
+	}
+
+	datasets := []string{}
+	for _, line := range lines {
+		col := strings.Split(line, "\t")
+
+		datasets = append(datasets, col[0])
+	}
+
+	if z.DatasetMetrics {
+		for _, line := range lines {
+			col := strings.Split(line, "\t")
+			if len(col) != len(properties) {
+				z.Log.Warnf("Invalid number of columns for line: %s", line)
+				continue
+			}
+
+			tags := map[string]string{"dataset": col[0]}
+			fields := map[string]interface{}{}
+
+			for i, key := range properties[1:] {
+				value, err := strconv.ParseInt(col[i+1], 10, 64)
+				if err != nil {
+					return "", fmt.Errorf("Error parsing %s %q: %s", key, col[i+1], err)
+				}
+				fields[key] = value
+			}
+
+			acc.AddFields("zfs_dataset", fields, tags)
+		}
+	}
+
+	return strings.Join(datasets, "::"), nil
+}
+
+// zpoolHealthStates are the health states a pool (or vdev) can be reported in
+// by `zpool list`/`zpool status`. See lib/libzfs/libzfs_pool.c in OpenZFS.
+var zpoolHealthStates = []string{
+	"online", "degraded", "faulted", "offline", "unavail", "removed", "suspended",
+}
+
+// gatherPoolHealth reports each pool's health as a set of zfs_pool
+// state_<health>=0|1 fields, one per possible health state, so alerting
+// rules don't have to decode a string field. All pools are read with a
+// single `zpool list` call rather than one exec per pool.
+func gatherPoolHealth(pools []poolInfo, acc telegraf.Accumulator) error {
+	if len(pools) == 0 {
+		return nil
+	}
+
+	lines, err := run("zpool", "list", "-Hp", "-o", "name,health")
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		col := strings.Split(line, "\t")
+		if len(col) != 2 {
+			continue
+		}
+
+		health := strings.ToLower(col[1])
+		tags := map[string]string{"pool": col[0]}
+		fields := make(map[string]interface{}, len(zpoolHealthStates))
+		for _, state := range zpoolHealthStates {
+			value := int64(0)
+			if state == health {
+				value = 1
+			}
+			fields["state_"+state] = value
+		}
+		acc.AddFields("zfs_pool", fields, tags)
+	}
+
+	return nil
+}
+
+// poolPropertiesColumns are passed to `zpool list -o` in this order; health
+// is requested for parity with `zpool list` output but is already reported
+// by gatherPoolHealth, so it's skipped here.
+var poolPropertiesColumns = []string{"name", "size", "alloc", "free", "frag", "cap", "dedup", "health", "expandsize"}
+
+// gatherPoolProperties reports zpool capacity, fragmentation and dedup
+// metrics that the io kstat doesn't expose. Fragmentation in particular is
+// a leading indicator of write-amplification problems on ZFS. All pools are
+// queried in a single zpool list call, the same pattern getPools() and
+// gatherPoolHealth() use, rather than one call per pool per Gather cycle.
+func gatherPoolProperties(pools []poolInfo, acc telegraf.Accumulator) error {
+	if len(pools) == 0 {
+		return nil
+	}
+
+	lines, err := run("zpool", "list", "-Hp", "-o", strings.Join(poolPropertiesColumns, ","))
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		col := strings.Split(line, "\t")
+		if len(col) != len(poolPropertiesColumns) {
+			return fmt.Errorf("unexpected zpool list output: %s", line)
+		}
+
+		fields := make(map[string]interface{})
+		for i, key := range []string{"size_bytes", "alloc_bytes", "free_bytes", "frag_percent", "cap_percent"} {
+			if value, err := parsePoolListInt(col[i+1]); err == nil {
+				fields[key] = value
+			}
+		}
+		if dedup, err := strconv.ParseFloat(strings.TrimSuffix(col[6], "x"), 64); err == nil {
+			fields["dedup_ratio"] = dedup
+		}
+		if expandsize, err := parsePoolListInt(col[8]); err == nil {
+			fields["expandsize_bytes"] = expandsize
+		}
+
+		acc.AddFields("zfs_pool", fields, map[string]string{"pool": col[0]})
+	}
+
+	return nil
+}
+
+// parsePoolListInt parses one numeric `zpool list` column, where "-" marks
+// a value the pool doesn't support (e.g. fragmentation on old-format pools).
+func parsePoolListInt(raw string) (int64, error) {
+	if raw == "-" {
+		return 0, fmt.Errorf("not applicable")
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+func gatherPoolStats(pool poolInfo, reader kstatReader, acc telegraf.Accumulator) error {
+	fields, err := reader.poolIO(pool.name)
+	if err != nil {
+		return err
+	}
+	acc.AddFields("zfs_pool", fields, map[string]string{"pool": pool.name})
+	return nil
+}
+
+// vdevIostatColumns is the number of tab-separated columns `zpool iostat`
+// emits per vdev with the -l flag: name, alloc, free, read/write ops,
+// read/write bytes, and the four average-latency pairs.
+const vdevIostatColumns = 15
+
+// vdevIostatFieldNames are the fields parsed from each column of a
+// `zpool iostat -vHpLPl` data row, in column order (after the vdev name).
+var vdevIostatFieldNames = []string{
+	"alloc", "free", "read_ops", "write_ops", "read_bytes", "write_bytes",
+	"total_wait_read_ns", "total_wait_write_ns",
+	"disk_wait_read_ns", "disk_wait_write_ns",
+	"sync_wait_read_ns", "sync_wait_write_ns",
+	"async_wait_read_ns", "async_wait_write_ns",
+}
+
+// gatherVdevStats shells out to `zpool iostat -vHpLPl <pool> 1 2` for one
+// pool at a time and emits a zfs_vdev measurement per vdev (tagged with
+// pool, vdev and parent_vdev), taking the second, interval-rate sample
+// rather than the first, which is the lifetime average since the pool was
+// created. This surfaces a single slow disk in a pool, which the
+// pool-aggregated zfs_pool counters cannot. Scoping the command to a
+// single pool avoids the ambiguity between the blank line `zpool iostat`
+// prints between each pool's block and the blank line it prints between
+// samples.
+//
+// This reports the -l flag's average per-vdev latencies, not the bucketed
+// latency histograms `zpool iostat -w`/`-r` expose on recent OpenZFS; those
+// are a different output format (one row per bucket boundary rather than
+// one row per vdev) and aren't collected here.
+func gatherVdevStats(pool poolInfo, acc telegraf.Accumulator) error {
+	lines, err := run("zpool", "iostat", "-vHpLPl", pool.name, "1", "2")
+	if err != nil {
+		return err
+	}
+
+	samples := splitIostatSamples(lines)
+	if len(samples) == 0 {
+		return nil
+	}
+
+	for _, record := range parseVdevIostatSample(pool.name, samples[len(samples)-1]) {
+		tags := map[string]string{"pool": pool.name, "vdev": record.vdev, "parent_vdev": record.parentVdev}
+		acc.AddFields("zfs_vdev", record.fields, tags)
+	}
+
+	return nil
+}
+
+// vdevRecord is one parsed row of a `zpool iostat -v` sample.
+type vdevRecord struct {
+	vdev       string
+	parentVdev string
+	fields     map[string]interface{}
+}
+
+// parseVdevIostatSample parses one sample (as split out by
+// splitIostatSamples) of `zpool iostat -vHpLPl <pool> 1 2` output for a
+// single pool into one vdevRecord per vdev. The pool's own aggregate row is
+// skipped, since it duplicates what gatherPoolStats already reports.
+//
+// Top-level rows (no leading indentation) aren't only the pool's own row:
+// `zpool iostat -v` also prints "logs", "cache" and "spares" pseudo-group
+// headers at that same zero indentation, each owning their own indented
+// child vdevs. Those are reported as vdevs of the pool, not mistaken for a
+// new pool.
+func parseVdevIostatSample(pool string, sample []string) []vdevRecord {
+	type ancestor struct {
+		depth int
+		name  string
+	}
+	var stack []ancestor
+	var records []vdevRecord
+
+	for _, line := range sample {
+		col := strings.Split(line, "\t")
+		if len(col) != vdevIostatColumns {
+			continue
+		}
+
+		depth := (len(col[0]) - len(strings.TrimLeft(col[0], " "))) / 2
+		name := strings.TrimSpace(col[0])
+
+		if depth == 0 {
+			stack = stack[:0]
+		} else {
+			for len(stack) > 0 && stack[len(stack)-1].depth >= depth {
+				stack = stack[:len(stack)-1]
+			}
+		}
+
+		parent := pool
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].name
+		}
+		stack = append(stack, ancestor{depth: depth, name: name})
+
+		if name == pool {
+			// the pool-aggregate row itself; already reported by
+			// gatherPoolStats, so there's nothing new to emit here
+			continue
+		}
+
+		fields := map[string]interface{}{}
+		for i, key := range vdevIostatFieldNames {
+			if value, err := parseIostatValue(col[i+1]); err == nil {
+				fields[key] = value
+			}
+		}
+
+		records = append(records, vdevRecord{vdev: name, parentVdev: parent, fields: fields})
+	}
+
+	return records
+}
+
+// parseIostatValue parses one numeric column of `zpool iostat` output,
+// where "-" marks a value that doesn't apply to that row (e.g. alloc/free
+// on a leaf vdev).
+func parseIostatValue(raw string) (int64, error) {
+	if raw == "-" {
+		return 0, fmt.Errorf("not applicable")
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+// splitIostatSamples splits the lines of `zpool iostat <interval> <count>`
+// output into one slice per sample; consecutive samples are separated by a
+// blank line.
+func splitIostatSamples(lines []string) [][]string {
+	var samples [][]string
+	var current []string
+	for _, line := range lines {
+		if len(strings.TrimSpace(line)) == 0 {
+			if len(current) > 0 {
+				samples = append(samples, current)
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		samples = append(samples, current)
+	}
+	return samples
+}
+
+// run is a var rather than a plain func so tests can stub it out, the same
+// way the Zfs.zdataset field lets gatherDatasetStats be tested without
+// shelling out.
+var run = func(command string, args ...string) ([]string, error) {
+	cmd := exec.Command(command, args...)
+	var outbuf, errbuf bytes.Buffer
+	cmd.Stdout = &outbuf
+	cmd.Stderr = &errbuf
+	err := cmd.Run()
+
+	stdout := strings.TrimSpace(outbuf.String())
+	stderr := strings.TrimSpace(errbuf.String())
+
+	if _, ok := err.(*exec.ExitError); ok {
+		return nil, fmt.Errorf("%s error: %s", command, stderr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s error: %s", command, err)
+	}
+	return strings.Split(stdout, "\n"), nil
+}
+
+func (z *Zfs) Gather(acc telegraf.Accumulator) error {
+	kstatMetrics := z.KstatMetrics
+	if len(kstatMetrics) == 0 {
+		// vdev_cache_stats is deprecated
+		// xuio_stats are ignored because as of Sep-2016, no known
+		// consumers of xuio exist on Linux
+		kstatMetrics = []string{"abdstats", "arcstats", "dnodestats", "dbufcachestats",
+			"dmu_tx", "fm", "vdev_mirror_stats", "zfetchstats", "zil"}
+	}
+
+	reader := newKstatReader(z.KstatPath)
+
+	// Pool discovery shells out to `zpool`, which plain kstat/arcstats
+	// collection doesn't need at all. Don't let that failure be fatal to
+	// the whole Gather; just skip the pool-dependent features for this
+	// cycle. The dataset listing below shells out to `zfs` and its failure
+	// still ends Gather with an error before any kstats are read.
+	pools, err := getPools()
+	if err != nil {
+		acc.AddError(fmt.Errorf("zfs: listing pools: %s", err))
+		pools = nil
+	}
+	tags := getTags(pools)
+
+	if z.PoolMetrics {
+		for _, pool := range pools {
+			if err := gatherPoolStats(pool, reader, acc); err != nil {
+				return err
+			}
+		}
+		if err := gatherPoolHealth(pools, acc); err != nil {
+			return err
+		}
+		if z.PoolProperties {
+			if err := gatherPoolProperties(pools, acc); err != nil {
+				return err
+			}
+		}
+	}
+	if z.VdevMetrics {
+		for _, pool := range pools {
+			if err := gatherVdevStats(pool, acc); err != nil {
+				return err
+			}
+		}
+	}
+
+	datasetNames, err := z.gatherDatasetStats(acc)
+	if err != nil {
+		return err
+	}
+	tags["datasets"] = datasetNames
+
+	fields := make(map[string]interface{})
+	for _, metric := range kstatMetrics {
+		subsystemFields, errs := reader.subsystem(metric)
+		for _, err := range errs {
+			acc.AddError(fmt.Errorf("zfs: %s: %s", metric, err))
+		}
+		for key, value := range subsystemFields {
+			fields[key] = value
+		}
+	}
+	addArcEfficiencyFields(fields)
+	acc.AddFields("zfs", fields, tags)
+	return nil
+}
+
+// addArcEfficiencyFields derives ARC hit-ratio and memory_available_bytes
+// fields from the raw arcstats counters so they don't have to be computed
+// in the TSDB's query language.
+func addArcEfficiencyFields(fields map[string]interface{}) {
+	addHitRatio(fields, "arcstats_hits", "arcstats_misses", "arcstats_hit_ratio")
+	addHitRatio(fields, "arcstats_demand_data_hits", "arcstats_demand_data_misses", "arcstats_data_demand_hit_ratio")
+	addHitRatio(fields, "arcstats_prefetch_data_hits", "arcstats_prefetch_data_misses", "arcstats_prefetch_hit_ratio")
+	addHitRatio(fields, "arcstats_l2_hits", "arcstats_l2_misses", "arcstats_l2_hit_ratio")
+
+	if _, ok := fields["arcstats_memory_available_bytes"]; ok {
+		return
+	}
+	free, okFree := toInt64(fields["arcstats_memory_free_bytes"])
+	noGrow, okNoGrow := toInt64(fields["arcstats_arc_no_grow"])
+	if okFree && okNoGrow {
+		fields["arcstats_memory_available_bytes"] = free - noGrow
+	}
+}
+
+func addHitRatio(fields map[string]interface{}, hitsKey, missesKey, ratioKey string) {
+	hits, ok := toFloat64(fields[hitsKey])
+	if !ok {
+		return
+	}
+	misses, ok := toFloat64(fields[missesKey])
+	if !ok {
+		return
+	}
+	if total := hits + misses; total > 0 {
+		fields[ratioKey] = hits / total
+	}
+}
+
+// toInt64 and toFloat64 normalize the int64/uint64 field values produced by
+// kstatReader.subsystem so derived metrics don't need to know which one a
+// given kstat happened to be declared as.
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func zdataset(properties []string) ([]string, error) {
+	return run("zfs", []string{"list", "-Hp", "-o", strings.Join(properties, ",")}...)
+}
+
+func init() {
+	inputs.Add("zfs", func() telegraf.Input {
+		return &Zfs{zdataset: zdataset}
+	})
+}
@@ -0,0 +1,89 @@
+// +build freebsd
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// freebsdKstatReader reads ZFS kstats from the FreeBSD sysctl(8) tree
+// (kstat.zfs.* on FreeBSD 13+), since /proc/spl/kstat doesn't exist there.
+// KstatPath is ignored on this platform.
+type freebsdKstatReader struct{}
+
+func newKstatReader(_ string) kstatReader {
+	return &freebsdKstatReader{}
+}
+
+// sysctlTree runs `sysctl <name>` and returns its "<oid>: <value>" lines
+// keyed by the last component of the oid, e.g. "kstat.zfs.misc.arcstats.hits:
+// 5504125" becomes fields["hits"] = "5504125".
+func sysctlTree(name string) (map[string]string, error) {
+	lines, err := run("sysctl", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(lines))
+	for _, line := range lines {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		oid, value := parts[0], parts[1]
+		key := oid[strings.LastIndex(oid, ".")+1:]
+		fields[key] = value
+	}
+
+	return fields, nil
+}
+
+func (r *freebsdKstatReader) poolIO(pool string) (map[string]interface{}, error) {
+	raw, err := sysctlTree("kstat.zfs." + pool + ".io")
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		fields[key] = v
+	}
+
+	return fields, nil
+}
+
+func (r *freebsdKstatReader) subsystem(metric string) (map[string]interface{}, []error) {
+	raw, err := sysctlTree("kstat.zfs.misc." + metric)
+	if err != nil {
+		// a missing sysctl node (e.g. the subsystem isn't compiled in)
+		// is not fatal to the rest of the gather
+		return nil, nil
+	}
+
+	var errs []error
+	fields := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		// FreeBSD's sysctl(8) doesn't expose the underlying kstat data
+		// type, so counters are reported as uint64, matching how
+		// OpenZFS declares the vast majority of them.
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing uint64 %q for %q: %s", value, key, err))
+			continue
+		}
+
+		name := key
+		if metric != "zil" && metric != "dmu_tx" && metric != "dnodestats" {
+			name = metric + "_" + key
+		}
+		fields[name] = v
+	}
+
+	return fields, errs
+}
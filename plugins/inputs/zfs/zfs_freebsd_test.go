@@ -0,0 +1,82 @@
+// +build freebsd
+
+package zfs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stubRun(t *testing.T, stub func(command string, args ...string) ([]string, error)) {
+	t.Helper()
+	orig := run
+	run = stub
+	t.Cleanup(func() { run = orig })
+}
+
+func TestSysctlTree(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		assert.Equal(t, "sysctl", command)
+		assert.Equal(t, []string{"kstat.zfs.misc.arcstats"}, args)
+		return []string{
+			"kstat.zfs.misc.arcstats.hits: 900",
+			"kstat.zfs.misc.arcstats.misses: 100",
+			"malformed line",
+		}, nil
+	})
+
+	fields, err := sysctlTree("kstat.zfs.misc.arcstats")
+	require.NoError(t, err)
+	assert.Equal(t, "900", fields["hits"])
+	assert.Equal(t, "100", fields["misses"])
+}
+
+func TestSysctlTreeError(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		return nil, fmt.Errorf("sysctl error: unknown oid")
+	})
+
+	_, err := sysctlTree("kstat.zfs.misc.arcstats")
+	assert.Error(t, err)
+}
+
+func TestFreebsdKstatReaderPoolIO(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		return []string{"kstat.zfs.tank.io.nread: 1024", "kstat.zfs.tank.io.nwritten: 2048"}, nil
+	})
+
+	reader := newKstatReader("")
+	fields, err := reader.poolIO("tank")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), fields["nread"])
+	assert.Equal(t, int64(2048), fields["nwritten"])
+}
+
+func TestFreebsdKstatReaderSubsystem(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		return []string{
+			"kstat.zfs.misc.arcstats.hits: 900",
+			"kstat.zfs.misc.arcstats.misses: 100",
+		}, nil
+	})
+
+	reader := newKstatReader("")
+	fields, errs := reader.subsystem("arcstats")
+	assert.Empty(t, errs)
+	assert.Equal(t, uint64(900), fields["arcstats_hits"])
+	assert.Equal(t, uint64(100), fields["arcstats_misses"])
+}
+
+func TestFreebsdKstatReaderSubsystemMissingOidIsNotFatal(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		return nil, fmt.Errorf("sysctl error: unknown oid")
+	})
+
+	reader := newKstatReader("")
+	fields, errs := reader.subsystem("arcstats")
+	assert.Nil(t, fields)
+	assert.Nil(t, errs)
+}
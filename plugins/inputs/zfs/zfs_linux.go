@@ -3,204 +3,123 @@
 package zfs
 
 import (
-	"bytes"
 	"fmt"
-	"os/exec"
-	"path/filepath"
 	"strconv"
 	"strings"
 
-	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal"
-	"github.com/influxdata/telegraf/plugins/inputs"
 )
 
-type poolInfo struct {
-	name       string
-	ioFilename string
-}
-
-func (z *Zfs) gatherDatasetStats(acc telegraf.Accumulator) (string, error) {
-	properties := []string{"name", "avail", "used", "usedsnap", "usedds"}
-
-	lines, err := z.zdataset(properties)
-	if err != nil {
-		return "", err
-	}
-
-	datasets := []string{}
-	for _, line := range lines {
-		col := strings.Split(line, "\t")
-
-		datasets = append(datasets, col[0])
-	}
-
-	if z.DatasetMetrics {
-		for _, line := range lines {
-			col := strings.Split(line, "\t")
-			if len(col) != len(properties) {
-				z.Log.Warnf("Invalid number of columns for line: %s", line)
-				continue
-			}
-
-			tags := map[string]string{"dataset": col[0]}
-			fields := map[string]interface{}{}
-
-			for i, key := range properties[1:] {
-				value, err := strconv.ParseInt(col[i+1], 10, 64)
-				if err != nil {
-					return "", fmt.Errorf("Error parsing %s %q: %s", key, col[i+1], err)
-				}
-				fields[key] = value
-			}
-
-			acc.AddFields("zfs_dataset", fields, tags)
-		}
-	}
-
-	return strings.Join(datasets, "::"), nil
-}
-
-func getPools(kstatPath string) []poolInfo {
-	pools := make([]poolInfo, 0)
-	poolsDirs, _ := filepath.Glob(kstatPath + "/*/io")
-
-	for _, poolDir := range poolsDirs {
-		poolDirSplit := strings.Split(poolDir, "/")
-		pool := poolDirSplit[len(poolDirSplit)-2]
-		pools = append(pools, poolInfo{name: pool, ioFilename: poolDir})
-	}
+// kstat named-stat data types, from sys/kstat.h (KSTAT_DATA_*). Several
+// OpenZFS kstats (e.g. arcstats.size, abdstats.linear_data_size) are
+// declared uint64 and can exceed math.MaxInt64 on large ARCs.
+const (
+	kstatDataInt64  = 3
+	kstatDataUint64 = 4
+)
 
-	return pools
+// linuxKstatReader reads ZFS kstats from their Linux procfs representation
+// under /proc/spl/kstat/zfs.
+type linuxKstatReader struct {
+	path string
 }
 
-func getTags(pools []poolInfo) map[string]string {
-	var poolNames string
-
-	for _, pool := range pools {
-		if len(poolNames) != 0 {
-			poolNames += "::"
-		}
-		poolNames += pool.name
+func newKstatReader(kstatPath string) kstatReader {
+	if len(kstatPath) == 0 {
+		kstatPath = "/proc/spl/kstat/zfs"
 	}
-
-	return map[string]string{"pools": poolNames}
+	return &linuxKstatReader{path: kstatPath}
 }
 
-func gatherPoolStats(pool poolInfo, acc telegraf.Accumulator) error {
-	lines, err := internal.ReadLines(pool.ioFilename)
+func (r *linuxKstatReader) poolIO(pool string) (map[string]interface{}, error) {
+	lines, err := internal.ReadLines(r.path + "/" + pool + "/io")
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	if len(lines) != 3 {
-		return err
+		return nil, fmt.Errorf("unexpected io kstat format for pool %s", pool)
 	}
 
 	keys := strings.Fields(lines[1])
 	values := strings.Fields(lines[2])
-
-	keyCount := len(keys)
-
-	if keyCount != len(values) {
-		return fmt.Errorf("Key and value count don't match Keys:%v Values:%v", keys, values)
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("key and value count don't match keys:%v values:%v", keys, values)
 	}
 
-	tag := map[string]string{"pool": pool.name}
-	fields := make(map[string]interface{})
-	for i := 0; i < keyCount; i++ {
+	fields := make(map[string]interface{}, len(keys))
+	for i, key := range keys {
 		value, err := strconv.ParseInt(values[i], 10, 64)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		fields[keys[i]] = value
+		fields[key] = value
 	}
-	acc.AddFields("zfs_pool", fields, tag)
 
-	return nil
+	return fields, nil
 }
 
-func run(command string, args ...string) ([]string, error) {
-	cmd := exec.Command(command, args...)
-	var outbuf, errbuf bytes.Buffer
-	cmd.Stdout = &outbuf
-	cmd.Stderr = &errbuf
-	err := cmd.Run()
-
-	stdout := strings.TrimSpace(outbuf.String())
-	stderr := strings.TrimSpace(errbuf.String())
-
-	if _, ok := err.(*exec.ExitError); ok {
-		return nil, fmt.Errorf("%s error: %s", command, stderr)
+// parseKstatRow parses one "<name> <type> <value>" row of a named-kstat
+// file into its name and a correctly typed int64 or uint64 field value.
+func parseKstatRow(line string) (name string, value interface{}, err error) {
+	row := strings.Fields(line)
+	if len(row) != 3 {
+		return "", nil, fmt.Errorf("unexpected kstat row format: %q", line)
 	}
-	return strings.Split(stdout, "\n"), nil
-}
 
-func (z *Zfs) Gather(acc telegraf.Accumulator) error {
-	kstatMetrics := z.KstatMetrics
-	if len(kstatMetrics) == 0 {
-		// vdev_cache_stats is deprecated
-		// xuio_stats are ignored because as of Sep-2016, no known
-		// consumers of xuio exist on Linux
-		kstatMetrics = []string{"abdstats", "arcstats", "dnodestats", "dbufcachestats",
-			"dmu_tx", "fm", "vdev_mirror_stats", "zfetchstats", "zil"}
+	name = row[0]
+	kind, err := strconv.Atoi(row[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid kstat type %q for %q: %s", row[1], name, err)
 	}
 
-	kstatPath := z.KstatPath
-	if len(kstatPath) == 0 {
-		kstatPath = "/proc/spl/kstat/zfs"
+	if kind == kstatDataUint64 {
+		v, err := strconv.ParseUint(row[2], 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("parsing uint64 %q for %q: %s", row[2], name, err)
+		}
+		return name, v, nil
 	}
 
-	pools := getPools(kstatPath)
-	tags := getTags(pools)
-
-	if z.PoolMetrics {
-		for _, pool := range pools {
-			err := gatherPoolStats(pool, acc)
-			if err != nil {
-				return err
-			}
-		}
+	v, err := strconv.ParseInt(row[2], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing int64 %q for %q: %s", row[2], name, err)
 	}
-	datasetNames, err := z.gatherDatasetStats(acc)
+	return name, v, nil
+}
+
+// subsystem reads one named-kstat file under r.path (e.g. "arcstats",
+// "abdstats") and returns its rows as telegraf fields. Fields are
+// prefixed with the subsystem name, except for zil, dmu_tx and
+// dnodestats, which have historically been published unprefixed. A row
+// that fails to parse is returned in errs and skipped, rather than
+// silently dropped or aborting the whole subsystem.
+func (r *linuxKstatReader) subsystem(metric string) (map[string]interface{}, []error) {
+	lines, err := internal.ReadLines(r.path + "/" + metric)
 	if err != nil {
-		return err
+		// a missing kstat file (e.g. the module disabled that
+		// subsystem) is not fatal to the rest of the gather
+		return nil, nil
 	}
-	tags["datasets"] = datasetNames
 
+	var errs []error
 	fields := make(map[string]interface{})
-	for _, metric := range kstatMetrics {
-		lines, err := internal.ReadLines(kstatPath + "/" + metric)
+	for i, line := range lines {
+		if i == 0 || i == 1 || len(line) < 1 {
+			continue
+		}
+
+		name, value, err := parseKstatRow(line)
 		if err != nil {
+			errs = append(errs, err)
 			continue
 		}
-		for i, line := range lines {
-			if i == 0 || i == 1 {
-				continue
-			}
-			if len(line) < 1 {
-				continue
-			}
-			rawData := strings.Split(line, " ")
-			key := metric + "_" + rawData[0]
-			if metric == "zil" || metric == "dmu_tx" || metric == "dnodestats" {
-				key = rawData[0]
-			}
-			rawValue := rawData[len(rawData)-1]
-			value, _ := strconv.ParseInt(rawValue, 10, 64)
-			fields[key] = value
+
+		key := metric + "_" + name
+		if metric == "zil" || metric == "dmu_tx" || metric == "dnodestats" {
+			key = name
 		}
+		fields[key] = value
 	}
-	acc.AddFields("zfs", fields, tags)
-	return nil
-}
-
-func zdataset(properties []string) ([]string, error) {
-	return run("zfs", []string{"list", "-Hp", "-o", strings.Join(properties, ",")}...)
-}
 
-func init() {
-	inputs.Add("zfs", func() telegraf.Input {
-		return &Zfs{zdataset: zdataset}
-	})
+	return fields, errs
 }
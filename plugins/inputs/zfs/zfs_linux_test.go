@@ -0,0 +1,91 @@
+// +build linux
+
+package zfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKstatFile(t *testing.T, dir, name, contents string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+}
+
+func TestLinuxKstatReaderPoolIO(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "tank"), 0755))
+	writeKstatFile(t, filepath.Join(dir, "tank"), "io", ""+
+		"1 1 0x01 1 80 1234567890 1234567890\n"+
+		"nread    nwritten reads    writes   wtime    wlentime wupdate  rtime    rlentime rupdate  wcnt     rcnt\n"+
+		"1024     2048     4        8        0        0        0        0        0        0        0        0\n")
+
+	reader := newKstatReader(dir)
+	fields, err := reader.poolIO("tank")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), fields["nread"])
+	assert.Equal(t, int64(2048), fields["nwritten"])
+	assert.Equal(t, int64(4), fields["reads"])
+}
+
+func TestLinuxKstatReaderPoolIOMissingPool(t *testing.T) {
+	reader := newKstatReader(t.TempDir())
+	_, err := reader.poolIO("tank")
+	assert.Error(t, err)
+}
+
+func TestLinuxKstatReaderSubsystem(t *testing.T) {
+	dir := t.TempDir()
+	writeKstatFile(t, dir, "arcstats", ""+
+		"20 1 0x01 90 4320 1234567890 1234567890\n"+
+		"name                            type data\n"+
+		"hits                            4    900\n"+
+		"misses                          4    100\n"+
+		"size                            4    18446744073709551615\n")
+
+	reader := newKstatReader(dir)
+	fields, errs := reader.subsystem("arcstats")
+	assert.Empty(t, errs)
+	assert.Equal(t, uint64(900), fields["arcstats_hits"])
+	assert.Equal(t, uint64(100), fields["arcstats_misses"])
+	assert.Equal(t, uint64(18446744073709551615), fields["arcstats_size"])
+}
+
+func TestLinuxKstatReaderSubsystemUnprefixed(t *testing.T) {
+	dir := t.TempDir()
+	writeKstatFile(t, dir, "zil", ""+
+		"20 1 0x01 1 80 1234567890 1234567890\n"+
+		"name                            type data\n"+
+		"zil_commit_count                4    5\n")
+
+	reader := newKstatReader(dir)
+	fields, errs := reader.subsystem("zil")
+	assert.Empty(t, errs)
+	assert.Equal(t, uint64(5), fields["zil_commit_count"])
+}
+
+func TestLinuxKstatReaderSubsystemMissingFileIsNotFatal(t *testing.T) {
+	reader := newKstatReader(t.TempDir())
+	fields, errs := reader.subsystem("arcstats")
+	assert.Nil(t, fields)
+	assert.Nil(t, errs)
+}
+
+func TestParseKstatRow(t *testing.T) {
+	name, value, err := parseKstatRow("hits                            4    900")
+	require.NoError(t, err)
+	assert.Equal(t, "hits", name)
+	assert.Equal(t, uint64(900), value)
+
+	name, value, err = parseKstatRow("size                            3    -1")
+	require.NoError(t, err)
+	assert.Equal(t, "size", name)
+	assert.Equal(t, int64(-1), value)
+
+	_, _, err = parseKstatRow("malformed")
+	assert.Error(t, err)
+}
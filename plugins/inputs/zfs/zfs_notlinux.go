@@ -0,0 +1,22 @@
+// +build !linux,!freebsd,!illumos,!solaris
+
+package zfs
+
+import "fmt"
+
+// noopKstatReader backs platforms the zfs input doesn't support reading
+// kstats on; Gather surfaces a clear error instead of silently reporting
+// nothing if a user enables it there.
+type noopKstatReader struct{}
+
+func newKstatReader(_ string) kstatReader {
+	return &noopKstatReader{}
+}
+
+func (r *noopKstatReader) poolIO(pool string) (map[string]interface{}, error) {
+	return nil, fmt.Errorf("zfs input does not support this platform")
+}
+
+func (r *noopKstatReader) subsystem(metric string) (map[string]interface{}, []error) {
+	return nil, []error{fmt.Errorf("zfs input does not support this platform")}
+}
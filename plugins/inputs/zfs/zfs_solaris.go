@@ -0,0 +1,90 @@
+// +build illumos solaris
+
+package zfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// illumosKstatReader reads ZFS kstats via the Illumos kstat(1) utility,
+// the CLI counterpart of the kstat(3KSTAT) library ZFS originated on.
+// KstatPath is ignored on this platform.
+type illumosKstatReader struct{}
+
+func newKstatReader(_ string) kstatReader {
+	return &illumosKstatReader{}
+}
+
+// kstatClass runs `kstat -p <module>:0:<name>:` and returns its
+// "module:instance:name:statistic\tvalue" lines keyed by statistic name.
+func kstatClass(module, name string) (map[string]string, error) {
+	lines, err := run("kstat", "-p", module+":0:"+name+":")
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(lines))
+	for _, line := range lines {
+		col := strings.Split(line, "\t")
+		if len(col) != 2 {
+			continue
+		}
+		id := strings.Split(col[0], ":")
+		if len(id) != 4 {
+			continue
+		}
+		fields[id[3]] = col[1]
+	}
+
+	return fields, nil
+}
+
+func (r *illumosKstatReader) poolIO(pool string) (map[string]interface{}, error) {
+	raw, err := kstatClass("zfs", pool)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		fields[key] = v
+	}
+
+	return fields, nil
+}
+
+func (r *illumosKstatReader) subsystem(metric string) (map[string]interface{}, []error) {
+	raw, err := kstatClass("zfs", metric)
+	if err != nil {
+		// a missing kstat (e.g. the subsystem isn't compiled in) is not
+		// fatal to the rest of the gather
+		return nil, nil
+	}
+
+	var errs []error
+	fields := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		// kstat -p doesn't print the kstat_named_t data type, so
+		// counters are reported as uint64, matching how OpenZFS
+		// declares the vast majority of them.
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parsing uint64 %q for %q: %s", value, key, err))
+			continue
+		}
+
+		name := key
+		if metric != "zil" && metric != "dmu_tx" && metric != "dnodestats" {
+			name = metric + "_" + key
+		}
+		fields[name] = v
+	}
+
+	return fields, errs
+}
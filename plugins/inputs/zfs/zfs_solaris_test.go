@@ -0,0 +1,82 @@
+// +build illumos solaris
+
+package zfs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stubRun(t *testing.T, stub func(command string, args ...string) ([]string, error)) {
+	t.Helper()
+	orig := run
+	run = stub
+	t.Cleanup(func() { run = orig })
+}
+
+func TestKstatClass(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		assert.Equal(t, "kstat", command)
+		assert.Equal(t, []string{"-p", "zfs:0:arcstats:"}, args)
+		return []string{
+			"zfs:0:arcstats:hits\t900",
+			"zfs:0:arcstats:misses\t100",
+			"malformed",
+		}, nil
+	})
+
+	fields, err := kstatClass("zfs", "arcstats")
+	require.NoError(t, err)
+	assert.Equal(t, "900", fields["hits"])
+	assert.Equal(t, "100", fields["misses"])
+}
+
+func TestKstatClassError(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		return nil, fmt.Errorf("kstat error: no such kstat")
+	})
+
+	_, err := kstatClass("zfs", "arcstats")
+	assert.Error(t, err)
+}
+
+func TestIllumosKstatReaderPoolIO(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		return []string{"zfs:0:tank:nread\t1024", "zfs:0:tank:nwritten\t2048"}, nil
+	})
+
+	reader := newKstatReader("")
+	fields, err := reader.poolIO("tank")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), fields["nread"])
+	assert.Equal(t, int64(2048), fields["nwritten"])
+}
+
+func TestIllumosKstatReaderSubsystem(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		return []string{
+			"zfs:0:arcstats:hits\t900",
+			"zfs:0:arcstats:misses\t100",
+		}, nil
+	})
+
+	reader := newKstatReader("")
+	fields, errs := reader.subsystem("arcstats")
+	assert.Empty(t, errs)
+	assert.Equal(t, uint64(900), fields["arcstats_hits"])
+	assert.Equal(t, uint64(100), fields["arcstats_misses"])
+}
+
+func TestIllumosKstatReaderSubsystemMissingKstatIsNotFatal(t *testing.T) {
+	stubRun(t, func(command string, args ...string) ([]string, error) {
+		return nil, fmt.Errorf("kstat error: no such kstat")
+	})
+
+	reader := newKstatReader("")
+	fields, errs := reader.subsystem("arcstats")
+	assert.Nil(t, fields)
+	assert.Nil(t, errs)
+}
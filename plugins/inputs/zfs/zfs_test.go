@@ -0,0 +1,146 @@
+package zfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitIostatSamples(t *testing.T) {
+	lines := []string{
+		"tank\t1\t2",
+		"  mirror-0\t1\t2",
+		"",
+		"tank\t3\t4",
+		"  mirror-0\t3\t4",
+		"",
+	}
+
+	samples := splitIostatSamples(lines)
+	require.Len(t, samples, 2)
+	assert.Equal(t, []string{"tank\t1\t2", "  mirror-0\t1\t2"}, samples[0])
+	assert.Equal(t, []string{"tank\t3\t4", "  mirror-0\t3\t4"}, samples[1])
+}
+
+func TestSplitIostatSamplesIgnoresTrailingBlankLines(t *testing.T) {
+	samples := splitIostatSamples([]string{"tank\t1\t2", "", ""})
+	require.Len(t, samples, 1)
+	assert.Equal(t, []string{"tank\t1\t2"}, samples[0])
+}
+
+func vdevLine(depth int, name string, values ...string) string {
+	line := strings.Repeat("  ", depth) + name
+	for _, v := range values {
+		line += "\t" + v
+	}
+	return line
+}
+
+func TestParseVdevIostatSample(t *testing.T) {
+	values := make([]string, vdevIostatColumns-1)
+	for i := range values {
+		values[i] = "0"
+	}
+
+	sample := []string{
+		vdevLine(0, "tank", values...),
+		vdevLine(1, "mirror-0", values...),
+		vdevLine(2, "sda1", values...),
+		vdevLine(2, "sdb1", values...),
+		vdevLine(0, "logs", values...),
+		vdevLine(1, "sdc1", values...),
+	}
+
+	records := parseVdevIostatSample("tank", sample)
+
+	got := map[string]string{}
+	for _, r := range records {
+		got[r.vdev] = r.parentVdev
+	}
+
+	// the pool's own aggregate row is skipped; gatherPoolStats already
+	// reports it
+	assert.NotContains(t, got, "tank")
+	assert.Equal(t, "tank", got["mirror-0"])
+	assert.Equal(t, "mirror-0", got["sda1"])
+	assert.Equal(t, "mirror-0", got["sdb1"])
+	// "logs" is a pseudo-group header at the same indentation as the
+	// pool's own row, not a new pool
+	assert.Equal(t, "tank", got["logs"])
+	assert.Equal(t, "logs", got["sdc1"])
+}
+
+func TestParseVdevIostatSampleSkipsMalformedRows(t *testing.T) {
+	records := parseVdevIostatSample("tank", []string{"tank\t1\t2"})
+	assert.Empty(t, records)
+}
+
+func TestParsePoolListInt(t *testing.T) {
+	v, err := parsePoolListInt("1234")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1234), v)
+
+	_, err = parsePoolListInt("-")
+	assert.Error(t, err)
+}
+
+func TestParseIostatValue(t *testing.T) {
+	v, err := parseIostatValue("42")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), v)
+
+	_, err = parseIostatValue("-")
+	assert.Error(t, err)
+}
+
+func TestAddHitRatio(t *testing.T) {
+	fields := map[string]interface{}{
+		"arcstats_hits":   uint64(90),
+		"arcstats_misses": uint64(10),
+	}
+	addHitRatio(fields, "arcstats_hits", "arcstats_misses", "arcstats_hit_ratio")
+	assert.Equal(t, 0.9, fields["arcstats_hit_ratio"])
+}
+
+func TestAddHitRatioNoData(t *testing.T) {
+	fields := map[string]interface{}{}
+	addHitRatio(fields, "arcstats_hits", "arcstats_misses", "arcstats_hit_ratio")
+	assert.NotContains(t, fields, "arcstats_hit_ratio")
+}
+
+func TestAddArcEfficiencyFields(t *testing.T) {
+	fields := map[string]interface{}{
+		"arcstats_hits":              uint64(9),
+		"arcstats_misses":            uint64(1),
+		"arcstats_memory_free_bytes": int64(100),
+		"arcstats_arc_no_grow":       int64(40),
+	}
+	addArcEfficiencyFields(fields)
+	assert.Equal(t, 0.9, fields["arcstats_hit_ratio"])
+	assert.Equal(t, int64(60), fields["arcstats_memory_available_bytes"])
+}
+
+func TestGatherDatasetStats(t *testing.T) {
+	z := &Zfs{
+		DatasetMetrics: true,
+		Log:            testutil.Logger{},
+		zdataset: func(properties []string) ([]string, error) {
+			return []string{"tank/data\t100\t200\t10\t20"}, nil
+		},
+	}
+
+	var acc testutil.Accumulator
+	datasets, err := z.gatherDatasetStats(&acc)
+	require.NoError(t, err)
+	assert.Equal(t, "tank/data", datasets)
+
+	acc.AssertContainsTaggedFields(t, "zfs_dataset", map[string]interface{}{
+		"avail":    int64(100),
+		"used":     int64(200),
+		"usedsnap": int64(10),
+		"usedds":   int64(20),
+	}, map[string]string{"dataset": "tank/data"})
+}